@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net"
+	"sort"
 	"strings"
 	"sync"
 
@@ -22,40 +23,158 @@ var (
 )
 
 type portMap struct {
-	p    map[int]struct{}
-	last int
+	p        map[int]struct{}
+	reserved map[int]struct{}
+	excluded map[int]struct{}
+	begin    int
+	end      int
+	last     int
 }
 
-func newPortMap() *portMap {
+func newPortMap(begin, end int) *portMap {
 	return &portMap{
-		p:    map[int]struct{}{},
-		last: endPortRange,
+		p:        map[int]struct{}{},
+		reserved: map[int]struct{}{},
+		excluded: map[int]struct{}{},
+		begin:    begin,
+		end:      end,
+		last:     end,
 	}
 }
 
 type protoMap map[string]*portMap
 
 func newProtoMap() protoMap {
-	return protoMap{
-		"tcp": newPortMap(),
-		"udp": newPortMap(),
+	pm := protoMap{}
+	for _, proto := range registeredProtocols() {
+		pm[proto] = newPortMap(beginPortRange, endPortRange)
 	}
+	return pm
 }
 
 type ipMapping map[string]protoMap
 
+var ErrAllPortsAllocated = errors.New("all ports are allocated")
+
+// protocols is the set of L4 identifiers the allocator understands. tcp, udp and sctp
+// (RFC 4960) are registered by default; downstream users can add more via
+// RegisterProtocol. protocolsMutex guards it independently of the allocator's data mutex,
+// since ErrUnknownProtocol.Error() reads it via registeredProtocols() and may be called by
+// a caller long after the data mutex that produced the error was released.
 var (
-	ErrAllPortsAllocated = errors.New("all ports are allocated")
-	ErrUnknownProtocol   = errors.New("unknown protocol")
+	protocolsMutex sync.Mutex
+	protocols      = map[string]struct{}{
+		"tcp":  {},
+		"udp":  {},
+		"sctp": {},
+	}
 )
 
+// RegisterProtocol adds name as a valid protocol identifier, giving every existing and
+// future ip a portMap slot for it. This lets downstream users add UDP-Lite, DCCP or other
+// custom L4 identifiers without forking the package.
+func RegisterProtocol(name string) {
+	protocolsMutex.Lock()
+	if _, ok := protocols[name]; ok {
+		protocolsMutex.Unlock()
+		return
+	}
+	protocols[name] = struct{}{}
+	protocolsMutex.Unlock()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, protomap := range globalMap {
+		if _, ok := protomap[name]; !ok {
+			protomap[name] = newPortMap(beginPortRange, endPortRange)
+		}
+	}
+}
+
+func isRegisteredProtocol(proto string) bool {
+	protocolsMutex.Lock()
+	defer protocolsMutex.Unlock()
+	_, ok := protocols[proto]
+	return ok
+}
+
+func registeredProtocols() []string {
+	protocolsMutex.Lock()
+	defer protocolsMutex.Unlock()
+	names := make([]string, 0, len(protocols))
+	for name := range protocols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ErrUnknownProtocol is returned when proto is not a registered protocol; see
+// RegisterProtocol.
+type ErrUnknownProtocol struct {
+	proto string
+}
+
+func (e ErrUnknownProtocol) Error() string {
+	return fmt.Sprintf("unknown protocol %q, must be one of: %s", e.proto, strings.Join(registeredProtocols(), ", "))
+}
+
 var (
 	mutex sync.Mutex
 
-	defaultIP = net.ParseIP("0.0.0.0")
-	globalMap = ipMapping{}
+	defaultIP   = net.ParseIP("0.0.0.0")
+	defaultIPv6 = net.ParseIP("::")
+	globalMap   = ipMapping{}
 )
 
+// isIPv6 reports whether ip belongs to the IPv6 address family.
+func isIPv6(ip net.IP) bool {
+	return ip.To4() == nil
+}
+
+// familyWildcard returns the wildcard address (0.0.0.0 or ::) for ip's address family.
+func familyWildcard(ip net.IP) net.IP {
+	if isIPv6(ip) {
+		return defaultIPv6
+	}
+	return defaultIP
+}
+
+// conflictsWithFamily reports whether port/proto is already taken on ip's wildcard
+// address, or, when ip is itself a wildcard address, on any specific address of the same
+// family — matching real bind() semantics, where binding the wildcard address claims the
+// port on every interface.
+func conflictsWithFamily(ip net.IP, proto string, port int) bool {
+	if ip.Equal(defaultIP) || ip.Equal(defaultIPv6) {
+		for ipstr, protomap := range globalMap {
+			if ipstr == ip.String() {
+				continue
+			}
+			other := net.ParseIP(ipstr)
+			if other == nil || isIPv6(other) != isIPv6(ip) {
+				continue
+			}
+			if mapping, ok := protomap[proto]; ok {
+				if _, taken := mapping.p[port]; taken {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	wildcard, ok := globalMap[familyWildcard(ip).String()]
+	if !ok {
+		return false
+	}
+	mapping, ok := wildcard[proto]
+	if !ok {
+		return false
+	}
+	_, taken := mapping.p[port]
+	return taken
+}
+
 type ErrPortAlreadyAllocated struct {
 	ip   string
 	port int
@@ -87,10 +206,73 @@ func init() {
 	}
 }
 
+// GetPortRange returns the default ephemeral port range used for ips and protos that have
+// no override set via SetPortRange. It defaults to the kernel's
+// /proc/sys/net/ipv4/ip_local_port_range, parsed once at init.
 func GetPortRange() (int, int) {
 	return beginPortRange, endPortRange
 }
 
+// SetPortRange overrides the ephemeral port range consulted by RequestPort and findPort
+// for ip and proto, e.g. to restrict 127.0.0.1/tcp to a Kubernetes NodePort-style range
+// while leaving other ips on the kernel default.
+func SetPortRange(ip net.IP, proto string, start, end int) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if !isRegisteredProtocol(proto) {
+		return ErrUnknownProtocol{proto}
+	}
+
+	if ip == nil {
+		ip = defaultIP
+	}
+	ipstr := ip.String()
+	protomap, ok := globalMap[ipstr]
+	if !ok {
+		protomap = newProtoMap()
+		globalMap[ipstr] = protomap
+	}
+	mapping, ok := protomap[proto]
+	if !ok {
+		return ErrUnknownProtocol{proto}
+	}
+	mapping.begin = start
+	mapping.end = end
+	mapping.last = end
+	return nil
+}
+
+// ExcludePorts permanently removes ports from ephemeral selection for ip and proto, e.g.
+// to blacklist well-known ports like 22, 80 or 443. Excluded ports can still be requested
+// explicitly via RequestPort.
+func ExcludePorts(ip net.IP, proto string, ports ...int) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if !isRegisteredProtocol(proto) {
+		return ErrUnknownProtocol{proto}
+	}
+
+	if ip == nil {
+		ip = defaultIP
+	}
+	ipstr := ip.String()
+	protomap, ok := globalMap[ipstr]
+	if !ok {
+		protomap = newProtoMap()
+		globalMap[ipstr] = protomap
+	}
+	mapping, ok := protomap[proto]
+	if !ok {
+		return ErrUnknownProtocol{proto}
+	}
+	for _, port := range ports {
+		mapping.excluded[port] = struct{}{}
+	}
+	return nil
+}
+
 func (e ErrPortAlreadyAllocated) IP() string {
 	return e.ip
 }
@@ -107,44 +289,389 @@ func (e ErrPortAlreadyAllocated) Error() string {
 	return fmt.Sprintf("Bind for %s:%d failed: port is already allocated", e.ip, e.port)
 }
 
+// Authorizer is implemented by plugins that want a say in port (de)allocation, modeled on
+// Docker's --authorization-plugin design. Registered authorizers are consulted in chain
+// order, without holding the allocator's data mutex, and the first denial wins.
+type Authorizer interface {
+	AuthorizePortRequest(ip net.IP, proto string, port int, caller string) error
+	AuthorizePortRelease(ip net.IP, proto string, port int, caller string) error
+}
+
+// ErrPortDenied is returned when an Authorizer in the chain rejects a port request or
+// release.
+type ErrPortDenied struct {
+	Plugin string
+	Reason string
+}
+
+func (e ErrPortDenied) Error() string {
+	return fmt.Sprintf("plugin %s denied the request: %s", e.Plugin, e.Reason)
+}
+
+// authzMutex guards the authorizer registry and chain independently of the allocator's
+// data mutex, so a plugin that hangs inside an Authorize call never prevents
+// RemoveAuthorizer from evicting it.
+var (
+	authzMutex      sync.Mutex
+	authorizers     = map[string]Authorizer{}
+	authorizerChain []string
+)
+
+// RegisterAuthorizer adds a (or replaces an existing) named Authorizer. Registering an
+// authorizer does not by itself put it in the request path; add it to the chain with
+// SetAuthorizationChain.
+func RegisterAuthorizer(name string, a Authorizer) {
+	authzMutex.Lock()
+	defer authzMutex.Unlock()
+	authorizers[name] = a
+}
+
+// SetAuthorizationChain sets the ordered list of registered authorizer names to consult on
+// every RequestPort and ReleasePort call.
+func SetAuthorizationChain(names ...string) {
+	authzMutex.Lock()
+	defer authzMutex.Unlock()
+	authorizerChain = append([]string{}, names...)
+}
+
+// RemoveAuthorizer drops name from the active chain without unregistering it, so a
+// hung or disabled plugin can be taken out of the request path without blocking
+// subsequent allocations, mirroring the fix applied to Docker's authz chain. It only needs
+// authzMutex, never the allocator's data mutex, so it can run while another goroutine is
+// stuck inside that plugin's Authorize call.
+func RemoveAuthorizer(name string) {
+	authzMutex.Lock()
+	defer authzMutex.Unlock()
+	for i, n := range authorizerChain {
+		if n == name {
+			authorizerChain = append(authorizerChain[:i], authorizerChain[i+1:]...)
+			return
+		}
+	}
+}
+
+// namedAuthorizer pairs a registered Authorizer with the name it was registered under, so
+// a chain snapshot can still report which plugin denied a request.
+type namedAuthorizer struct {
+	name string
+	Authorizer
+}
+
+// chainSnapshot copies the current ordered chain under authzMutex and returns it. Callers
+// must invoke the returned authorizers without holding authzMutex (or the allocator's data
+// mutex), so a plugin that hangs mid-call can't block RemoveAuthorizer or other allocations.
+func chainSnapshot() []namedAuthorizer {
+	authzMutex.Lock()
+	defer authzMutex.Unlock()
+
+	chain := make([]namedAuthorizer, 0, len(authorizerChain))
+	for _, name := range authorizerChain {
+		if a, ok := authorizers[name]; ok {
+			chain = append(chain, namedAuthorizer{name: name, Authorizer: a})
+		}
+	}
+	return chain
+}
+
+// authorizeRequest runs the authorization chain for a port request. It must be called
+// without holding the allocator's data mutex, since plugins run arbitrary code.
+func authorizeRequest(ip net.IP, proto string, port int, caller string) error {
+	for _, na := range chainSnapshot() {
+		if err := na.AuthorizePortRequest(ip, proto, port, caller); err != nil {
+			return ErrPortDenied{Plugin: na.name, Reason: err.Error()}
+		}
+	}
+	return nil
+}
+
+// authorizeRelease runs the authorization chain for a port release. It must be called
+// without holding the allocator's data mutex, since plugins run arbitrary code.
+func authorizeRelease(ip net.IP, proto string, port int, caller string) error {
+	for _, na := range chainSnapshot() {
+		if err := na.AuthorizePortRelease(ip, proto, port, caller); err != nil {
+			return ErrPortDenied{Plugin: na.name, Reason: err.Error()}
+		}
+	}
+	return nil
+}
+
 // RequestPort requests new port from global ports pool for specified ip and proto.
-// If port is 0 it returns first free port. Otherwise it cheks port availability
-// in pool and return that port or error if port is already busy.
-func RequestPort(ip net.IP, proto string, port int) (int, error) {
+// If port is 0 it returns first free port in the range configured for ip and proto via
+// SetPortRange, falling back to the kernel default, skipping any ports excluded via
+// ExcludePorts. Otherwise it cheks port availability in pool and return that port or
+// error if port is already busy. caller identifies the requester to the authorization
+// chain (see RegisterAuthorizer) and may be empty.
+func RequestPort(ip net.IP, proto string, port int, caller string) (int, error) {
+	if ip == nil {
+		ip = defaultIP
+	}
+
 	mutex.Lock()
-	defer mutex.Unlock()
+	got, err := requestPortLocked(ip, proto, port)
+	mutex.Unlock()
+	if err != nil {
+		return 0, err
+	}
 
-	if proto != "tcp" && proto != "udp" {
-		return 0, ErrUnknownProtocol
+	// Authorize the port that was actually resolved (not the raw, possibly-0 request), and
+	// without holding mutex, so a slow or hung plugin can't block every other allocation or
+	// RemoveAuthorizer's ability to evict it.
+	if err := authorizeRequest(ip, proto, got, caller); err != nil {
+		mutex.Lock()
+		releasePortLocked(ip, proto, got)
+		mutex.Unlock()
+		return 0, err
 	}
+	return got, nil
+}
 
+// RequestPortDualStack atomically reserves port (or, if port is 0, the same
+// ephemerally-chosen port number) on ip and on the wildcard address of the opposite
+// address family, so a caller modelling a dual-stack listener never ends up holding just
+// one half of the pair. Both ip and the wildcard peer are run through the authorization
+// chain; if either reservation or authorization fails, both are rolled back. caller
+// identifies the requester to the authorization chain and may be empty.
+func RequestPortDualStack(ip net.IP, proto string, port int, caller string) (int, error) {
 	if ip == nil {
 		ip = defaultIP
 	}
+	peer := defaultIPv6
+	if isIPv6(ip) {
+		peer = defaultIP
+	}
+
+	mutex.Lock()
+	got, err := requestPortLocked(ip, proto, port)
+	if err != nil {
+		mutex.Unlock()
+		return 0, err
+	}
+	if _, err := requestPortLocked(peer, proto, got); err != nil {
+		releasePortLocked(ip, proto, got)
+		mutex.Unlock()
+		return 0, err
+	}
+	mutex.Unlock()
+
+	if err := authorizeRequest(ip, proto, got, caller); err != nil {
+		mutex.Lock()
+		releasePortLocked(ip, proto, got)
+		releasePortLocked(peer, proto, got)
+		mutex.Unlock()
+		return 0, err
+	}
+	if err := authorizeRequest(peer, proto, got, caller); err != nil {
+		mutex.Lock()
+		releasePortLocked(ip, proto, got)
+		releasePortLocked(peer, proto, got)
+		mutex.Unlock()
+		return 0, err
+	}
+	return got, nil
+}
+
+// requestPortLocked is RequestPort's allocation logic without the authorization chain or
+// locking, shared by RequestPortDualStack to reserve the same port across both address
+// families. Callers must hold mutex.
+func requestPortLocked(ip net.IP, proto string, port int) (int, error) {
+	if !isRegisteredProtocol(proto) {
+		return 0, ErrUnknownProtocol{proto}
+	}
+
 	ipstr := ip.String()
 	protomap, ok := globalMap[ipstr]
 	if !ok {
 		protomap = newProtoMap()
 		globalMap[ipstr] = protomap
 	}
-	mapping := protomap[proto]
+	mapping, ok := protomap[proto]
+	if !ok {
+		return 0, ErrUnknownProtocol{proto}
+	}
 	if port > 0 {
-		if _, ok := mapping.p[port]; !ok {
-			mapping.p[port] = struct{}{}
-			return port, nil
+		if _, ok := mapping.p[port]; ok {
+			return 0, NewErrPortAlreadyAllocated(ipstr, port)
+		}
+		if conflictsWithFamily(ip, proto, port) {
+			return 0, NewErrPortAlreadyAllocated(ipstr, port)
+		}
+		mapping.p[port] = struct{}{}
+		return port, nil
+	}
+
+	return mapping.findPort(ip, proto)
+}
+
+// releasePortLocked is ReleasePort's release logic without the authorization chain or
+// locking. Callers must hold mutex. proto need not be registered: releasing a port for an
+// unregistered (or never-allocated) protocol is a no-op rather than a panic.
+func releasePortLocked(ip net.IP, proto string, port int) {
+	protomap, ok := globalMap[ip.String()]
+	if !ok {
+		return
+	}
+	mapping, ok := protomap[proto]
+	if !ok {
+		return
+	}
+	delete(mapping.p, port)
+	delete(mapping.reserved, port)
+}
+
+// Allocation describes a single allocated ip/proto/port tuple.
+type Allocation struct {
+	IP    net.IP
+	Proto string
+	Port  int
+}
+
+// Allocations returns a snapshot of every currently allocated ip/proto/port tuple, across
+// both address families, for observability.
+func Allocations() []Allocation {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	var allocations []Allocation
+	for ipstr, protomap := range globalMap {
+		ip := net.ParseIP(ipstr)
+		for proto, mapping := range protomap {
+			for port := range mapping.p {
+				allocations = append(allocations, Allocation{IP: ip, Proto: proto, Port: port})
+			}
+		}
+	}
+	return allocations
+}
+
+// ReleasePort releases port from global ports pool for specified ip and proto. caller
+// identifies the requester to the authorization chain and may be empty.
+func ReleasePort(ip net.IP, proto string, port int, caller string) error {
+	if ip == nil {
+		ip = defaultIP
+	}
+
+	if !isRegisteredProtocol(proto) {
+		return ErrUnknownProtocol{proto}
+	}
+
+	if err := authorizeRelease(ip, proto, port, caller); err != nil {
+		return err
+	}
+
+	mutex.Lock()
+	releasePortLocked(ip, proto, port)
+	mutex.Unlock()
+	return nil
+}
+
+// reserveRangeLocked is ReserveRange's allocation logic without the authorization chain or
+// locking. Callers must hold mutex.
+func reserveRangeLocked(ip net.IP, proto string, start, end int) error {
+	if !isRegisteredProtocol(proto) {
+		return ErrUnknownProtocol{proto}
+	}
+
+	ipstr := ip.String()
+	protomap, ok := globalMap[ipstr]
+	if !ok {
+		protomap = newProtoMap()
+		globalMap[ipstr] = protomap
+	}
+	mapping, ok := protomap[proto]
+	if !ok {
+		return ErrUnknownProtocol{proto}
+	}
+
+	for port := start; port <= end; port++ {
+		if _, ok := mapping.p[port]; ok {
+			return NewErrPortAlreadyAllocated(ipstr, port)
+		}
+		if conflictsWithFamily(ip, proto, port) {
+			return NewErrPortAlreadyAllocated(ipstr, port)
 		}
-		return 0, NewErrPortAlreadyAllocated(ipstr, port)
 	}
 
-	port, err := mapping.findPort()
+	for port := start; port <= end; port++ {
+		mapping.p[port] = struct{}{}
+		mapping.reserved[port] = struct{}{}
+	}
+	return nil
+}
+
+// releaseRangeLocked is ReleaseRange's release logic without the authorization chain or
+// locking. Callers must hold mutex.
+func releaseRangeLocked(ip net.IP, proto string, start, end int) {
+	protomap, ok := globalMap[ip.String()]
+	if !ok {
+		return
+	}
+	mapping, ok := protomap[proto]
+	if !ok {
+		return
+	}
+	for port := start; port <= end; port++ {
+		delete(mapping.p, port)
+		delete(mapping.reserved, port)
+	}
+}
+
+// ReserveRange reserves every port in [start, end] for ip and proto as a single atomic
+// block, for callers that need to carve out a contiguous pool (e.g. RTP) rather than
+// allocating one port at a time. Either all ports in the range are marked allocated, or
+// none are and an error is returned — including when a specific ip's range overlaps a
+// wildcard allocation, or vice versa (see conflictsWithFamily). If the authorization chain
+// denies any port in the range, the whole reservation is rolled back. caller identifies
+// the requester to the authorization chain and may be empty.
+func ReserveRange(ip net.IP, proto string, start, end int, caller string) error {
+	if ip == nil {
+		ip = defaultIP
+	}
+
+	mutex.Lock()
+	err := reserveRangeLocked(ip, proto, start, end)
+	mutex.Unlock()
 	if err != nil {
-		return 0, err
+		return err
 	}
-	return port, nil
+
+	for port := start; port <= end; port++ {
+		if err := authorizeRequest(ip, proto, port, caller); err != nil {
+			mutex.Lock()
+			releaseRangeLocked(ip, proto, start, end)
+			mutex.Unlock()
+			return err
+		}
+	}
+	return nil
 }
 
-// ReleasePort releases port from global ports pool for specified ip and proto.
-func ReleasePort(ip net.IP, proto string, port int) error {
+// ReleaseRange releases every port in [start, end] previously reserved for ip and proto
+// via ReserveRange. caller identifies the requester to the authorization chain and may be
+// empty.
+func ReleaseRange(ip net.IP, proto string, start, end int, caller string) error {
+	if ip == nil {
+		ip = defaultIP
+	}
+
+	if !isRegisteredProtocol(proto) {
+		return ErrUnknownProtocol{proto}
+	}
+
+	for port := start; port <= end; port++ {
+		if err := authorizeRelease(ip, proto, port, caller); err != nil {
+			return err
+		}
+	}
+
+	mutex.Lock()
+	releaseRangeLocked(ip, proto, start, end)
+	mutex.Unlock()
+	return nil
+}
+
+// ReservedPorts returns the ports currently held for ip and proto via ReserveRange, for
+// introspection.
+func ReservedPorts(ip net.IP, proto string) []int {
 	mutex.Lock()
 	defer mutex.Unlock()
 
@@ -155,8 +682,16 @@ func ReleasePort(ip net.IP, proto string, port int) error {
 	if !ok {
 		return nil
 	}
-	delete(protomap[proto].p, port)
-	return nil
+	mapping, ok := protomap[proto]
+	if !ok {
+		return nil
+	}
+	ports := make([]int, 0, len(mapping.reserved))
+	for port := range mapping.reserved {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	return ports
 }
 
 // ReleaseAll releases all ports for all ips.
@@ -167,20 +702,26 @@ func ReleaseAll() error {
 	return nil
 }
 
-func (pm *portMap) findPort() (int, error) {
+func (pm *portMap) findPort(ip net.IP, proto string) (int, error) {
 	port := pm.last
-	start, end := GetPortRange()
-	for i := 0; i <= end-start; i++ {
+	for i := 0; i <= pm.end-pm.begin; i++ {
 		port++
-		if port > end {
-			port = start
+		if port > pm.end {
+			port = pm.begin
 		}
 
-		if _, ok := pm.p[port]; !ok {
-			pm.p[port] = struct{}{}
-			pm.last = port
-			return port, nil
+		if _, ok := pm.excluded[port]; ok {
+			continue
+		}
+		if _, ok := pm.p[port]; ok {
+			continue
+		}
+		if conflictsWithFamily(ip, proto, port) {
+			continue
 		}
+		pm.p[port] = struct{}{}
+		pm.last = port
+		return port, nil
 	}
 	return 0, ErrAllPortsAllocated
 }
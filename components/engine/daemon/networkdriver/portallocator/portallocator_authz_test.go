@@ -0,0 +1,59 @@
+package portallocator
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+// testAuthorizer is a minimal Authorizer whose decision is fixed at construction, for
+// exercising the authorization chain.
+type testAuthorizer struct {
+	allow bool
+}
+
+func (a *testAuthorizer) AuthorizePortRequest(ip net.IP, proto string, port int, caller string) error {
+	if !a.allow {
+		return errors.New("denied by test authorizer")
+	}
+	return nil
+}
+
+func (a *testAuthorizer) AuthorizePortRelease(ip net.IP, proto string, port int, caller string) error {
+	if !a.allow {
+		return errors.New("denied by test authorizer")
+	}
+	return nil
+}
+
+func TestAuthorizationChainAllowDenyRemove(t *testing.T) {
+	reset()
+	defer SetAuthorizationChain()
+
+	RegisterAuthorizer("allow", &testAuthorizer{allow: true})
+	RegisterAuthorizer("deny", &testAuthorizer{allow: false})
+
+	SetAuthorizationChain("allow")
+	if _, err := RequestPort(net.ParseIP("127.0.0.1"), "tcp", 9400, ""); err != nil {
+		t.Fatalf("expected the allow authorizer to permit the request: %v", err)
+	}
+
+	SetAuthorizationChain("deny")
+	_, err := RequestPort(net.ParseIP("127.0.0.1"), "tcp", 9401, "")
+	if err == nil {
+		t.Fatal("expected the deny authorizer to reject the request")
+	}
+	if _, ok := err.(ErrPortDenied); !ok {
+		t.Fatalf("expected ErrPortDenied, got %T: %v", err, err)
+	}
+	if _, err := RequestPort(net.ParseIP("127.0.0.1"), "tcp", 9401, ""); err == nil {
+		t.Fatal("expected the deny authorizer to reject the repeated request")
+	} else if _, ok := err.(ErrPortDenied); !ok {
+		t.Fatalf("a denied request must not leave the port allocated, got %T: %v", err, err)
+	}
+
+	RemoveAuthorizer("deny")
+	if _, err := RequestPort(net.ParseIP("127.0.0.1"), "tcp", 9401, ""); err != nil {
+		t.Fatalf("expected the request to succeed once the deny authorizer is removed from the chain: %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+package portallocator
+
+import (
+	"net"
+	"testing"
+)
+
+// reset clears all allocator state between tests so they don't interfere with each other.
+func reset() {
+	mutex.Lock()
+	globalMap = ipMapping{}
+	mutex.Unlock()
+	SetAuthorizationChain()
+}
+
+func TestSetPortRangeOverride(t *testing.T) {
+	reset()
+	ip := net.ParseIP("127.0.0.1")
+
+	if err := SetPortRange(ip, "tcp", 9700, 9702); err != nil {
+		t.Fatalf("SetPortRange: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		port, err := RequestPort(ip, "tcp", 0, "")
+		if err != nil {
+			t.Fatalf("RequestPort: %v", err)
+		}
+		if port < 9700 || port > 9702 {
+			t.Fatalf("expected port in the overridden range [9700, 9702], got %d", port)
+		}
+	}
+
+	if _, err := RequestPort(ip, "tcp", 0, ""); err != ErrAllPortsAllocated {
+		t.Fatalf("expected ErrAllPortsAllocated once the overridden range is exhausted, got %v", err)
+	}
+}
+
+func TestExcludePorts(t *testing.T) {
+	reset()
+	ip := net.ParseIP("127.0.0.1")
+
+	if err := SetPortRange(ip, "tcp", 9710, 9712); err != nil {
+		t.Fatalf("SetPortRange: %v", err)
+	}
+	if err := ExcludePorts(ip, "tcp", 9711); err != nil {
+		t.Fatalf("ExcludePorts: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		port, err := RequestPort(ip, "tcp", 0, "")
+		if err != nil {
+			t.Fatalf("RequestPort: %v", err)
+		}
+		if port == 9711 {
+			t.Fatal("excluded port 9711 must not be handed out for an ephemeral request")
+		}
+	}
+
+	if err := ReleaseAll(); err != nil {
+		t.Fatalf("ReleaseAll: %v", err)
+	}
+	if err := SetPortRange(ip, "tcp", 9710, 9712); err != nil {
+		t.Fatalf("SetPortRange: %v", err)
+	}
+	if err := ExcludePorts(ip, "tcp", 9711); err != nil {
+		t.Fatalf("ExcludePorts: %v", err)
+	}
+	if _, err := RequestPort(ip, "tcp", 9711, ""); err != nil {
+		t.Fatalf("an excluded port should still be allocatable when requested explicitly: %v", err)
+	}
+}
+
+func TestReserveRangeAtomicRollback(t *testing.T) {
+	reset()
+	ip := net.ParseIP("127.0.0.1")
+
+	if _, err := RequestPort(ip, "tcp", 9005, ""); err != nil {
+		t.Fatalf("RequestPort: %v", err)
+	}
+
+	if err := ReserveRange(ip, "tcp", 9000, 9010, ""); err == nil {
+		t.Fatal("expected ReserveRange to fail because 9005 is already allocated")
+	}
+
+	for port := 9000; port <= 9010; port++ {
+		if port == 9005 {
+			continue
+		}
+		if _, err := RequestPort(ip, "tcp", port, ""); err != nil {
+			t.Fatalf("port %d should not have been left reserved by the failed ReserveRange: %v", port, err)
+		}
+	}
+}
+
+func TestRequestPortSCTP(t *testing.T) {
+	reset()
+	ip := net.ParseIP("127.0.0.1")
+
+	port, err := RequestPort(ip, "sctp", 9720, "")
+	if err != nil {
+		t.Fatalf("RequestPort: %v", err)
+	}
+	if port != 9720 {
+		t.Fatalf("expected port 9720, got %d", port)
+	}
+	if _, err := RequestPort(ip, "sctp", 9720, ""); err == nil {
+		t.Fatal("expected a second request for the same sctp port to fail")
+	}
+}
+
+func TestRegisterProtocolRoundTrip(t *testing.T) {
+	reset()
+	RegisterProtocol("sctp2")
+	ip := net.ParseIP("127.0.0.1")
+
+	port, err := RequestPort(ip, "sctp2", 9721, "")
+	if err != nil {
+		t.Fatalf("RequestPort for a dynamically registered protocol: %v", err)
+	}
+	if port != 9721 {
+		t.Fatalf("expected port 9721, got %d", port)
+	}
+
+	if _, err := RequestPort(ip, "udplite", 9722, ""); err == nil {
+		t.Fatal("expected an unregistered protocol to be rejected")
+	}
+}
+
+// TestRegisterProtocolBackfillsExistingIPs pins the invariant that every ip already present
+// in globalMap gets backfilled with a portMap slot for a protocol registered afterwards —
+// the assumption SetPortRange, ExcludePorts, requestPortLocked and reserveRangeLocked all
+// rely on to read protomap[proto] for a registered proto without it being absent.
+func TestRegisterProtocolBackfillsExistingIPs(t *testing.T) {
+	reset()
+	ip := net.ParseIP("127.0.0.1")
+
+	if _, err := RequestPort(ip, "tcp", 9730, ""); err != nil {
+		t.Fatalf("RequestPort: %v", err)
+	}
+
+	RegisterProtocol("sctp3")
+
+	if err := SetPortRange(ip, "sctp3", 9740, 9742); err != nil {
+		t.Fatalf("SetPortRange on a pre-existing ip for a protocol registered afterwards: %v", err)
+	}
+	if err := ExcludePorts(ip, "sctp3", 9741); err != nil {
+		t.Fatalf("ExcludePorts on a pre-existing ip for a protocol registered afterwards: %v", err)
+	}
+	if _, err := RequestPort(ip, "sctp3", 9740, ""); err != nil {
+		t.Fatalf("RequestPort on a pre-existing ip for a protocol registered afterwards: %v", err)
+	}
+}
+
+func TestReserveRangeReleaseRange(t *testing.T) {
+	reset()
+	ip := net.ParseIP("127.0.0.1")
+
+	if err := ReserveRange(ip, "tcp", 9100, 9110, ""); err != nil {
+		t.Fatalf("ReserveRange: %v", err)
+	}
+
+	if ports := ReservedPorts(ip, "tcp"); len(ports) != 11 {
+		t.Fatalf("expected 11 reserved ports, got %d: %v", len(ports), ports)
+	}
+
+	if err := ReleaseRange(ip, "tcp", 9100, 9110, ""); err != nil {
+		t.Fatalf("ReleaseRange: %v", err)
+	}
+
+	if ports := ReservedPorts(ip, "tcp"); len(ports) != 0 {
+		t.Fatalf("expected no reserved ports after ReleaseRange, got %v", ports)
+	}
+	if _, err := RequestPort(ip, "tcp", 9105, ""); err != nil {
+		t.Fatalf("expected released port to be available again: %v", err)
+	}
+}
+
+func TestReleasePortClearsReservedRangeMember(t *testing.T) {
+	reset()
+	ip := net.ParseIP("127.0.0.1")
+
+	if err := ReserveRange(ip, "tcp", 9110, 9112, ""); err != nil {
+		t.Fatalf("ReserveRange: %v", err)
+	}
+
+	if err := ReleasePort(ip, "tcp", 9111, ""); err != nil {
+		t.Fatalf("ReleasePort: %v", err)
+	}
+
+	if ports := ReservedPorts(ip, "tcp"); len(ports) != 2 {
+		t.Fatalf("expected 2 reserved ports after releasing one member, got %d: %v", len(ports), ports)
+	}
+}
@@ -0,0 +1,101 @@
+package portallocator
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestWildcardConflictsWithSpecific(t *testing.T) {
+	reset()
+
+	if _, err := RequestPort(net.ParseIP("127.0.0.1"), "tcp", 9200, ""); err != nil {
+		t.Fatalf("RequestPort: %v", err)
+	}
+
+	if _, err := RequestPort(net.ParseIP("0.0.0.0"), "tcp", 9200, ""); err == nil {
+		t.Fatal("expected a wildcard request to conflict with an existing specific-ip allocation")
+	}
+}
+
+func TestSpecificConflictsWithWildcard(t *testing.T) {
+	reset()
+
+	if _, err := RequestPort(net.ParseIP("0.0.0.0"), "tcp", 9300, ""); err != nil {
+		t.Fatalf("RequestPort: %v", err)
+	}
+
+	if _, err := RequestPort(net.ParseIP("127.0.0.1"), "tcp", 9300, ""); err == nil {
+		t.Fatal("expected a specific-ip request to conflict with an existing wildcard allocation")
+	}
+}
+
+func TestRequestPortDualStackRollback(t *testing.T) {
+	reset()
+
+	if _, err := RequestPort(net.ParseIP("::"), "tcp", 9500, ""); err != nil {
+		t.Fatalf("RequestPort: %v", err)
+	}
+
+	if _, err := RequestPortDualStack(net.ParseIP("0.0.0.0"), "tcp", 9500, ""); err == nil {
+		t.Fatal("expected RequestPortDualStack to fail because the IPv6 wildcard already holds the port")
+	}
+
+	if _, err := RequestPort(net.ParseIP("0.0.0.0"), "tcp", 9500, ""); err != nil {
+		t.Fatalf("expected the IPv4 half of the failed dual-stack request to have been rolled back: %v", err)
+	}
+}
+
+func TestRequestPortDualStackSuccess(t *testing.T) {
+	reset()
+
+	port, err := RequestPortDualStack(net.ParseIP("0.0.0.0"), "tcp", 9600, "")
+	if err != nil {
+		t.Fatalf("RequestPortDualStack: %v", err)
+	}
+	if port != 9600 {
+		t.Fatalf("expected port 9600, got %d", port)
+	}
+
+	if _, err := RequestPort(net.ParseIP("127.0.0.1"), "tcp", 9600, ""); err == nil {
+		t.Fatal("expected the IPv4 wildcard reservation to block a specific IPv4 address")
+	}
+	if _, err := RequestPort(net.ParseIP("::1"), "tcp", 9600, ""); err == nil {
+		t.Fatal("expected the IPv6 wildcard reservation to block a specific IPv6 address")
+	}
+}
+
+// wildcardDenyAuthorizer denies any request for a wildcard address, to verify that the
+// wildcard peer reserved by RequestPortDualStack actually goes through the chain.
+type wildcardDenyAuthorizer struct{}
+
+func (wildcardDenyAuthorizer) AuthorizePortRequest(ip net.IP, proto string, port int, caller string) error {
+	if ip.IsUnspecified() {
+		return errors.New("denied by test authorizer: wildcard binds are not allowed")
+	}
+	return nil
+}
+
+func (wildcardDenyAuthorizer) AuthorizePortRelease(ip net.IP, proto string, port int, caller string) error {
+	return nil
+}
+
+func TestRequestPortDualStackAuthorizesPeer(t *testing.T) {
+	reset()
+	defer SetAuthorizationChain()
+
+	RegisterAuthorizer("no-wildcards", wildcardDenyAuthorizer{})
+	SetAuthorizationChain("no-wildcards")
+
+	if _, err := RequestPortDualStack(net.ParseIP("127.0.0.1"), "tcp", 9610, ""); err == nil {
+		t.Fatal("expected the wildcard peer leg to be denied by the authorization chain")
+	}
+
+	SetAuthorizationChain()
+	if _, err := RequestPort(net.ParseIP("127.0.0.1"), "tcp", 9610, ""); err != nil {
+		t.Fatalf("expected both legs of the denied dual-stack request to have been rolled back: %v", err)
+	}
+	if _, err := RequestPort(net.ParseIP("::"), "tcp", 9610, ""); err != nil {
+		t.Fatalf("expected both legs of the denied dual-stack request to have been rolled back: %v", err)
+	}
+}